@@ -0,0 +1,147 @@
+// Copyright (c) 2019 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	// CRISandboxConfig is the crictl PodSandboxConfig used to boot the pod
+	// sandbox exercised by the CRI cgroup specs below.
+	CRISandboxConfig = "cri_fixtures/sandbox.json"
+
+	// CRIContainerConfig is the crictl ContainerConfig used to create the
+	// container exercised by the CRI cgroup specs below; it pins
+	// cpu_shares=738 and cpuset_cpus="1", mirroring the docker-driven specs.
+	CRIContainerConfig = "cri_fixtures/container.json"
+)
+
+// crictlRunPodSandbox creates a pod sandbox from the given JSON config and
+// returns its pod ID.
+func crictlRunPodSandbox(configPath string) (string, error) {
+	stdout, stderr, exitCode := RunCommand("crictl", "runp", configPath)
+	if exitCode != 0 {
+		return "", fmt.Errorf("Could not run pod sandbox: %v", stderr)
+	}
+	return strings.Trim(stdout, "\n\t "), nil
+}
+
+// crictlRunContainer creates and starts a container in the given pod using
+// the given container JSON config, and returns its container ID.
+func crictlRunContainer(containerConfigPath, podConfigPath, podID string) (string, error) {
+	stdout, stderr, exitCode := RunCommand("crictl", "create", podID, containerConfigPath, podConfigPath)
+	if exitCode != 0 {
+		return "", fmt.Errorf("Could not create container: %v", stderr)
+	}
+	cid := strings.Trim(stdout, "\n\t ")
+
+	if _, stderr, exitCode := RunCommand("crictl", "start", cid); exitCode != 0 {
+		return "", fmt.Errorf("Could not start container: %v", stderr)
+	}
+
+	return cid, nil
+}
+
+// sandboxCgroupParent reads the linux.cgroup_parent slice requested by a
+// PodSandboxConfig fixture, the same value kubelet derives from the pod's
+// QoS class and which the runtime actually honours when creating the
+// sandbox's cgroup.
+func sandboxCgroupParent(configPath string) (string, error) {
+	c, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+
+	var config struct {
+		Linux struct {
+			CgroupParent string `json:"cgroup_parent"`
+		} `json:"linux"`
+	}
+	if err := json.Unmarshal(c, &config); err != nil {
+		return "", err
+	}
+
+	return config.Linux.CgroupParent, nil
+}
+
+var _ = Describe("Checking cgroups through the CRI (containerd)", func() {
+	var (
+		podID       string
+		containerID string
+		podParent   string
+		err         error
+	)
+
+	AfterEach(func() {
+		if containerID != "" {
+			RunCommand("crictl", "stop", containerID)
+			RunCommand("crictl", "rm", containerID)
+		}
+		if podID != "" {
+			RunCommand("crictl", "stopp", podID)
+			RunCommand("crictl", "rmp", podID)
+		}
+	})
+
+	Context("booting a pod via crictl", func() {
+		It("should expose the configured cpu shares and cpuset under the CRI cgroup path", func() {
+			if os.Getuid() != 0 {
+				Skip("only root user can inspect cgroups")
+			}
+
+			if _, err := exec.LookPath("crictl"); err != nil {
+				Skip("crictl is not available on this host")
+			}
+
+			podID, err = crictlRunPodSandbox(CRISandboxConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			containerID, err = crictlRunContainer(CRIContainerConfig, CRISandboxConfig, podID)
+			Expect(err).ToNot(HaveOccurred())
+
+			podParent, err = sandboxCgroupParent(CRISandboxConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			resolver := cgroupResolverFor(runtimeContainerd)
+
+			cpuCgroupPath, err := resolver.resolve(podParent, containerID, cgroupCPU)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpuCgroupPath).Should(BeADirectory())
+
+			cpusetCgroupPath, err := resolver.resolve(podParent, containerID, cgroupCpuset)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpusetCgroupPath).Should(BeADirectory())
+
+			sharesFile, cpusetFile := sysCPUSharesFile, sysCpusetCpusFile
+			expectedShares, expectedCpuset := "738", "1"
+
+			if isCgroupV2() {
+				sharesFile = sysCPUWeightFile
+				shares, err := strconv.Atoi(expectedShares)
+				Expect(err).ToNot(HaveOccurred())
+				expectedShares = strconv.Itoa(cpuSharesToWeight(shares))
+			}
+
+			shares, err := ioutil.ReadFile(filepath.Join(cpuCgroupPath, sharesFile))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.Trim(string(shares), "\n\t ")).To(Equal(expectedShares))
+
+			cpuset, err := ioutil.ReadFile(filepath.Join(cpusetCgroupPath, cpusetFile))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.Trim(string(cpuset), "\n\t ")).To(Equal(expectedCpuset))
+		})
+	})
+})
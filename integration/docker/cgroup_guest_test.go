@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package docker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countOnlineCPUs parses the range-list format used by
+// /sys/devices/system/cpu/online (e.g. "0-2,4") and returns how many CPUs it
+// describes.
+func countOnlineCPUs(onlineList string) (int, error) {
+	onlineList = strings.Trim(onlineList, "\n\t ")
+	if onlineList == "" {
+		return 0, nil
+	}
+
+	count := 0
+	for _, r := range strings.Split(onlineList, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, err
+		}
+
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, err
+			}
+		}
+
+		count += hi - lo + 1
+	}
+
+	return count, nil
+}
+
+func containerExecOutput(id string, cmd ...string) (string, error) {
+	args := append([]string{id}, cmd...)
+	stdout, stderr, exitCode := dockerExec(args...)
+	if exitCode != 0 {
+		return "", fmt.Errorf("Could not exec into container: %v", stderr)
+	}
+	return stdout, nil
+}
+
+var _ = Describe("Checking guest-side cgroup enforcement", func() {
+	var (
+		id       string
+		exitCode int
+	)
+
+	BeforeEach(func() {
+		id = randomDockerName()
+		_, _, exitCode = dockerRun("--cpus=1", "--cpu-shares=800", "--cpuset-cpus=0", "-dt", "--name", id, Image, "sh")
+		Expect(exitCode).To(BeZero())
+	})
+
+	AfterEach(func() {
+		Expect(RemoveDockerContainer(id)).To(BeTrue())
+		Expect(ExistDockerContainer(id)).NotTo(BeTrue())
+	})
+
+	Context("growing --cpus with docker update", func() {
+		It("should hotplug vCPUs the guest kernel can see", func() {
+			expectedCpuset := "0-2"
+			_, _, exitCode = dockerUpdate("--cpus=2.5", "--cpuset-cpus", expectedCpuset, id)
+			Expect(exitCode).To(BeZero())
+
+			online, err := containerExecOutput(id, "cat", "/sys/devices/system/cpu/online")
+			Expect(err).ToNot(HaveOccurred())
+
+			onlineCount, err := countOnlineCPUs(online)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(onlineCount).To(Equal(int(math.Ceil(2.5))))
+
+			cpuset, err := containerExecOutput(id, "cat", "/sys/fs/cgroup/cpuset/cpuset.cpus")
+			if err != nil {
+				cpuset, err = containerExecOutput(id, "cat", "/sys/fs/cgroup/cpuset.cpus")
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.Trim(cpuset, "\n\t ")).To(Equal(expectedCpuset))
+		})
+	})
+
+	Context("shrinking --cpus with docker update", func() {
+		It("should hot-unplug vCPUs and reduce the guest-visible CPU count", func() {
+			_, _, exitCode = dockerUpdate("--cpus=3", "--cpuset-cpus", "0-2", id)
+			Expect(exitCode).To(BeZero())
+
+			nprocBefore, err := containerExecOutput(id, "nproc")
+			Expect(err).ToNot(HaveOccurred())
+			before, err := strconv.Atoi(strings.Trim(nprocBefore, "\n\t "))
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedCpuset := "0"
+			_, _, exitCode = dockerUpdate("--cpus=1", "--cpuset-cpus", expectedCpuset, id)
+			Expect(exitCode).To(BeZero())
+
+			nprocAfter, err := containerExecOutput(id, "nproc")
+			Expect(err).ToNot(HaveOccurred())
+			after, err := strconv.Atoi(strings.Trim(nprocAfter, "\n\t "))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(after).To(BeNumerically("<", before))
+
+			online, err := containerExecOutput(id, "cat", "/sys/devices/system/cpu/online")
+			Expect(err).ToNot(HaveOccurred())
+
+			onlineCount, err := countOnlineCPUs(online)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(onlineCount).To(Equal(int(math.Ceil(1))))
+		})
+	})
+})
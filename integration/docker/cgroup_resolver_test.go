@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// runtimeKind identifies the container runtime/CRI shim that created a
+// sandbox, since each lays out its cgroup hierarchy differently.
+type runtimeKind string
+
+const (
+	runtimeDocker     runtimeKind = "docker"
+	runtimeContainerd runtimeKind = "containerd"
+	runtimeCRIO       runtimeKind = "cri-o"
+)
+
+// cgroupPathResolver resolves the host cgroup directory backing a
+// container, however the runtime that created it names and nests it.
+type cgroupPathResolver interface {
+	resolve(sandboxID, containerID string, t cgroupType) (string, error)
+}
+
+// cgroupResolverFor returns the resolver appropriate for the given runtime.
+func cgroupResolverFor(kind runtimeKind) cgroupPathResolver {
+	switch kind {
+	case runtimeContainerd, runtimeCRIO:
+		return criCgroupResolver{kind: kind}
+	default:
+		return dockerCgroupResolver{}
+	}
+}
+
+// dockerCgroupResolver lays containers out under /docker/<id>, or under the
+// --cgroup-parent the container was started with, following the same
+// cgroupfs/systemd and v1/v2 rules as containerCgroupPath.
+type dockerCgroupResolver struct{}
+
+func (dockerCgroupResolver) resolve(sandboxID, containerID string, t cgroupType) (string, error) {
+	if driver, err := dockerCgroupDriver(); err == nil && driver == "systemd" {
+		// sandboxID is a slice name here (e.g. "user.slice"), or empty when
+		// no --cgroup-parent was given; systemdCgroupPath falls back to
+		// system.slice in the latter case. Passing it straight through
+		// (rather than substituting dockerCgroupName first) keeps that
+		// fallback reachable.
+		return systemdCgroupPath(t, sandboxID, containerID)
+	}
+
+	parentCgroup := dockerCgroupName
+	if sandboxID != "" {
+		parentCgroup = sandboxID
+	}
+
+	if isCgroupV2() {
+		return filepath.Join(sysCgroupPath, parentCgroup, containerID), nil
+	}
+
+	return filepath.Join(sysCgroupPath, string(t), parentCgroup, containerID), nil
+}
+
+// criCgroupResolver lays pods and containers out the way kubelet's CRI
+// integration does: the pod's own slice (nested under its QoS class and
+// kubepods, e.g. kubepods-besteffort-pod<uid>.slice), holding the runtime's
+// own scope for each container, e.g.
+// kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod<uid>.slice/cri-containerd-<id>.scope
+//
+// sandboxCgroupParent is the pod's actual cgroup parent slice, as set via
+// the PodSandboxConfig's linux.cgroup_parent (kubelet derives this from the
+// pod's QoS class; there is no fixed mapping this resolver can assume).
+type criCgroupResolver struct {
+	kind runtimeKind
+}
+
+func (r criCgroupResolver) resolve(sandboxCgroupParent, containerID string, t cgroupType) (string, error) {
+	parent := expandSystemdSlice(sandboxCgroupParent)
+	if parent == "" {
+		parent = sandboxCgroupParent
+	}
+
+	scopePrefix := "cri-containerd"
+	if r.kind == runtimeCRIO {
+		scopePrefix = "crio"
+	}
+	scope := fmt.Sprintf("%s-%s.scope", scopePrefix, containerID)
+
+	if isCgroupV2() {
+		return filepath.Join(sysCgroupPath, parent, scope), nil
+	}
+
+	return filepath.Join(sysCgroupPath, string(t), parent, scope), nil
+}
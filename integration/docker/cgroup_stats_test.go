@@ -0,0 +1,320 @@
+// Copyright (c) 2019 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	cgroupMemory cgroupType = "memory"
+	cgroupBlkio  cgroupType = "blkio"
+	cgroupPids   cgroupType = "pids"
+)
+
+const (
+	sysCPUAcctUsageFile      = "cpuacct.usage"
+	sysCPUStatFile           = "cpu.stat"
+	sysMemoryUsageFile       = "memory.usage_in_bytes"
+	sysMemoryCurrentFile     = "memory.current"
+	sysBlkioServiceBytesFile = "blkio.io_service_bytes"
+	sysIOStatFile            = "io.stat"
+	sysPidsCurrentFile       = "pids.current"
+)
+
+// CPUStats holds the CPU accounting numbers exposed by the container cgroup.
+type CPUStats struct {
+	UsageUsec     uint64
+	ThrottledUsec uint64
+	NrThrottled   uint64
+}
+
+// MemoryStats holds the memory accounting numbers exposed by the container cgroup.
+type MemoryStats struct {
+	UsageBytes uint64
+}
+
+// IOStats holds the block IO accounting numbers exposed by the container cgroup.
+type IOStats struct {
+	ServiceBytes uint64
+}
+
+// PIDsStats holds the number of tasks currently charged to the container cgroup.
+type PIDsStats struct {
+	Current uint64
+}
+
+// Stats is a point-in-time snapshot of a container's resource usage, read
+// directly from its host cgroup, on either the v1 or the v2 hierarchy.
+type Stats struct {
+	CPU    CPUStats
+	Memory MemoryStats
+	IO     IOStats
+	PIDs   PIDsStats
+}
+
+func readCgroupUint64(path string) (uint64, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.Trim(string(c), "\n\t "), 10, 64)
+}
+
+// parseFlatKeyedFile parses files like cpu.stat, made of one "key value" pair
+// per line.
+func parseFlatKeyedFile(path string) (map[string]uint64, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]uint64{}
+	for _, line := range strings.Split(strings.Trim(string(c), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[fields[0]] = v
+	}
+	return stats, nil
+}
+
+// parseNestedKeyedFile parses files like io.stat, made of a "<major>:<minor>"
+// device token followed by "key=value" pairs, summing each key across devices.
+func parseNestedKeyedFile(path string) (map[string]uint64, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]uint64{}
+	for _, line := range strings.Split(strings.Trim(string(c), "\n"), "\n") {
+		for _, f := range strings.Fields(line) {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			stats[kv[0]] += v
+		}
+	}
+	return stats, nil
+}
+
+func readCPUStats(name string) (CPUStats, error) {
+	cpuCgroupPath, err := containerCgroupPath(name, cgroupCPU)
+	if err != nil {
+		return CPUStats{}, err
+	}
+
+	stat, err := parseFlatKeyedFile(filepath.Join(cpuCgroupPath, sysCPUStatFile))
+	if err != nil {
+		return CPUStats{}, err
+	}
+
+	if isCgroupV2() {
+		return CPUStats{
+			UsageUsec:     stat["usage_usec"],
+			ThrottledUsec: stat["throttled_usec"],
+			NrThrottled:   stat["nr_throttled"],
+		}, nil
+	}
+
+	usage, err := readCgroupUint64(filepath.Join(cpuCgroupPath, sysCPUAcctUsageFile))
+	if err != nil {
+		return CPUStats{}, err
+	}
+
+	// v1's cpu.stat reports throttled_time in nanoseconds, unlike v2's
+	// throttled_usec.
+	return CPUStats{
+		UsageUsec:     usage / 1000,
+		ThrottledUsec: stat["throttled_time"] / 1000,
+		NrThrottled:   stat["nr_throttled"],
+	}, nil
+}
+
+func readMemoryStats(name string) (MemoryStats, error) {
+	memCgroupPath, err := containerCgroupPath(name, cgroupMemory)
+	if err != nil {
+		return MemoryStats{}, err
+	}
+
+	file := sysMemoryUsageFile
+	if isCgroupV2() {
+		file = sysMemoryCurrentFile
+	}
+
+	usage, err := readCgroupUint64(filepath.Join(memCgroupPath, file))
+	if err != nil {
+		return MemoryStats{}, err
+	}
+	return MemoryStats{UsageBytes: usage}, nil
+}
+
+func readIOStats(name string) (IOStats, error) {
+	blkioCgroupPath, err := containerCgroupPath(name, cgroupBlkio)
+	if err != nil {
+		return IOStats{}, err
+	}
+
+	if isCgroupV2() {
+		stat, err := parseNestedKeyedFile(filepath.Join(blkioCgroupPath, sysIOStatFile))
+		if err != nil {
+			return IOStats{}, err
+		}
+		return IOStats{ServiceBytes: stat["rbytes"] + stat["wbytes"]}, nil
+	}
+
+	c, err := ioutil.ReadFile(filepath.Join(blkioCgroupPath, sysBlkioServiceBytesFile))
+	if err != nil {
+		return IOStats{}, err
+	}
+
+	var total uint64
+	for _, line := range strings.Split(strings.Trim(string(c), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[1] != "Total" {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[2], 10, 64); err == nil {
+			total += v
+		}
+	}
+	return IOStats{ServiceBytes: total}, nil
+}
+
+func readPIDsStats(name string) (PIDsStats, error) {
+	pidsCgroupPath, err := containerCgroupPath(name, cgroupPids)
+	if err != nil {
+		return PIDsStats{}, err
+	}
+
+	current, err := readCgroupUint64(filepath.Join(pidsCgroupPath, sysPidsCurrentFile))
+	if err != nil {
+		return PIDsStats{}, err
+	}
+	return PIDsStats{Current: current}, nil
+}
+
+// ContainerCgroupStats reads a point-in-time snapshot of a running
+// container's resource usage straight from its host cgroup.
+func ContainerCgroupStats(name string) (Stats, error) {
+	cpu, err := readCPUStats(name)
+	if err != nil {
+		return Stats{}, fmt.Errorf("Could not read CPU stats: %v", err)
+	}
+
+	mem, err := readMemoryStats(name)
+	if err != nil {
+		return Stats{}, fmt.Errorf("Could not read memory stats: %v", err)
+	}
+
+	io, err := readIOStats(name)
+	if err != nil {
+		return Stats{}, fmt.Errorf("Could not read IO stats: %v", err)
+	}
+
+	pids, err := readPIDsStats(name)
+	if err != nil {
+		return Stats{}, fmt.Errorf("Could not read pids stats: %v", err)
+	}
+
+	return Stats{CPU: cpu, Memory: mem, IO: io, PIDs: pids}, nil
+}
+
+// SampleContainerCgroupStats polls a container's cgroup stats every interval
+// for the given duration, returning the series of snapshots taken.
+func SampleContainerCgroupStats(name string, interval, duration time.Duration) ([]Stats, error) {
+	var series []Stats
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	deadline := time.After(duration)
+	for {
+		stats, err := ContainerCgroupStats(name)
+		if err != nil {
+			return series, err
+		}
+		series = append(series, stats)
+
+		select {
+		case <-deadline:
+			return series, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+var _ = Describe("Checking container cgroup stats", func() {
+	var (
+		id       string
+		exitCode int
+	)
+
+	BeforeEach(func() {
+		id = randomDockerName()
+	})
+
+	AfterEach(func() {
+		Expect(ExistDockerContainer(id)).NotTo(BeTrue())
+	})
+
+	Context("with a tight CPU quota", func() {
+		It("should report throttled time", func() {
+			_, _, exitCode = dockerRun("--cpus=0.1", "-dt", "--name", id, Image, "sh", "-c", "while true; do :; done")
+			Expect(exitCode).To(BeZero())
+
+			series, err := SampleContainerCgroupStats(id, 500*time.Millisecond, 5*time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(series).ToNot(BeEmpty())
+
+			last := series[len(series)-1]
+			Expect(last.CPU.ThrottledUsec).To(BeNumerically(">", 0))
+
+			Expect(RemoveDockerContainer(id)).To(BeTrue())
+		})
+	})
+
+	Context("with a growing memory allocation", func() {
+		It("should track the allocation in memory.current", func() {
+			// Allocate gradually, one 1 MiB step every 200ms, so the
+			// sampling window below observes the allocation in progress
+			// rather than racing a single burst write that may already be
+			// done by the time the baseline is read.
+			_, _, exitCode = dockerRun("--memory=256m", "-dt", "--name", id, Image, "sh", "-c",
+				"i=0; while [ $i -lt 64 ]; do dd if=/dev/zero of=/dev/shm/pad$i bs=1M count=1 2>/dev/null; "+
+					"i=$((i+1)); sleep 0.2; done; sleep 30")
+			Expect(exitCode).To(BeZero())
+
+			stats, err := ContainerCgroupStats(id)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() (uint64, error) {
+				s, err := ContainerCgroupStats(id)
+				return s.Memory.UsageBytes, err
+			}, 15*time.Second, 500*time.Millisecond).Should(BeNumerically(">", stats.Memory.UsageBytes))
+
+			Expect(RemoveDockerContainer(id)).To(BeTrue())
+		})
+	})
+})
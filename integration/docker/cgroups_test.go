@@ -10,7 +10,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -19,8 +22,9 @@ import (
 type cgroupType string
 
 const (
-	cgroupCPU    cgroupType = "cpu"
-	cgroupCpuset            = "cpuset"
+	cgroupCPU     cgroupType = "cpu"
+	cgroupCpuset  cgroupType = "cpuset"
+	cgroupFreezer cgroupType = "freezer"
 )
 
 const (
@@ -30,8 +34,62 @@ const (
 	sysCPUQuotaFile   = "cpu.cfs_quota_us"
 	sysCPUPeriodFile  = "cpu.cfs_period_us"
 	sysCpusetCpusFile = "cpuset.cpus"
+
+	// cgroup v2 unified hierarchy: one mount, no per-controller directory,
+	// and most limits are expressed through different files.
+	sysCPUWeightFile = "cpu.weight"
+	sysCPUMaxFile    = "cpu.max"
+
+	sysMemoryLimitFile     = "memory.limit_in_bytes"
+	sysMemorySoftLimitFile = "memory.soft_limit_in_bytes"
+	sysMemorySwapLimitFile = "memory.memsw.limit_in_bytes"
+	sysMemoryMaxFile       = "memory.max"
+	sysMemorySwapMaxFile   = "memory.swap.max"
+	sysMemoryLowFile       = "memory.low"
+	sysBlkioWeightFile     = "blkio.weight"
+	sysBlkioReadBpsFile    = "blkio.throttle.read_bps_device"
+	sysBlkioWriteIopsFile  = "blkio.throttle.write_iops_device"
+	sysIOMaxFile           = "io.max"
+	sysPidsMaxFile         = "pids.max"
+	sysFreezerStateFile    = "freezer.state"
+	sysCgroupFreezeFile    = "cgroup.freeze"
+	freezerStateFrozen     = "FROZEN"
+	freezerStateThawed     = "THAWED"
+
+	// https://www.kernel.org/doc/html/latest/admin-guide/cgroup-v2.html
+	cgroup2SuperMagic = 0x63677270
+)
+
+var (
+	cgroupV2Once sync.Once
+	cgroupV2     bool
 )
 
+// isCgroupV2 reports whether the host uses the cgroup v2 unified hierarchy,
+// where /sys/fs/cgroup is a single cgroup2 mount rather than one cpu/cpuset/...
+// mount per controller.
+func isCgroupV2() bool {
+	cgroupV2Once.Do(func() {
+		if _, err := os.Stat(filepath.Join(sysCgroupPath, "cgroup.controllers")); err == nil {
+			cgroupV2 = true
+			return
+		}
+
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(sysCgroupPath, &stat); err == nil {
+			cgroupV2 = int64(stat.Type) == cgroup2SuperMagic
+		}
+	})
+	return cgroupV2
+}
+
+// cpuSharesToWeight converts a cgroup v1 cpu.shares value into its cgroup v2
+// cpu.weight equivalent, following the kernel's linear mapping between the
+// two ranges ([2, 262144] for shares, [1, 10000] for weight).
+func cpuSharesToWeight(shares int) int {
+	return 1 + ((shares-2)*9999)/262142
+}
+
 func containerID(name string) (string, error) {
 	stdout, stderr, exitCode := dockerInspect("--format", "{{.Id}}", name)
 	if exitCode != 0 {
@@ -48,17 +106,67 @@ func containerCgroupParent(name string) (string, error) {
 	return strings.Trim(stdout, "\n\t "), nil
 }
 
+// dockerCgroupDriver returns the cgroup driver ("cgroupfs" or "systemd") the
+// docker daemon was configured with.
+func dockerCgroupDriver() (string, error) {
+	stdout, stderr, exitCode := dockerInfo("--format", "{{.CgroupDriver}}")
+	if exitCode != 0 {
+		return "", fmt.Errorf("Could not get docker cgroup driver: %v", stderr)
+	}
+	return strings.Trim(stdout, "\n\t "), nil
+}
+
+// expandSystemdSlice turns a systemd slice name (e.g. "user-1000.slice") into
+// the path of slices nested under it (e.g. "user.slice/user-1000.slice"), the
+// way systemd itself lays them out under /sys/fs/cgroup.
+func expandSystemdSlice(slice string) string {
+	if slice == "" || slice == "-.slice" {
+		return ""
+	}
+
+	parts := strings.Split(strings.TrimSuffix(slice, ".slice"), "-")
+	segments := make([]string, 0, len(parts))
+	prefix := ""
+	for _, p := range parts {
+		if prefix != "" {
+			prefix += "-"
+		}
+		prefix += p
+		segments = append(segments, prefix+".slice")
+	}
+
+	return filepath.Join(segments...)
+}
+
+// systemdCgroupPath resolves the cgroup directory docker creates for a
+// container when the daemon's cgroup driver is "systemd": the container runs
+// in a transient "docker-<id>.scope" unit nested under the parent slice.
+func systemdCgroupPath(t cgroupType, parentCgroup, id string) (string, error) {
+	slice := expandSystemdSlice(parentCgroup)
+	if slice == "" {
+		slice = "system.slice"
+	}
+	scope := fmt.Sprintf("%s-%s.scope", dockerCgroupName, id)
+
+	if isCgroupV2() {
+		return filepath.Join(sysCgroupPath, slice, scope), nil
+	}
+
+	return filepath.Join(sysCgroupPath, string(t), slice, scope), nil
+}
+
 func containerCgroupPath(name string, t cgroupType) (string, error) {
-	parentCgroup := dockerCgroupName
-	if path, err := containerCgroupParent(name); err != nil && path != "" {
-		parentCgroup = path
+	id, err := containerID(name)
+	if err != nil || id == "" {
+		return "", fmt.Errorf("Could not get container cgroup path")
 	}
 
-	if id, err := containerID(name); err == nil && id != "" {
-		return filepath.Join(sysCgroupPath, string(t), parentCgroup, id), nil
+	parentCgroup := ""
+	if path, err := containerCgroupParent(name); err == nil && path != "" {
+		parentCgroup = path
 	}
 
-	return "", fmt.Errorf("Could not get container cgroup path")
+	return cgroupResolverFor(runtimeDocker).resolve(parentCgroup, id, t)
 }
 
 func addProcessToCgroup(pid int, cgroupPath string) error {
@@ -72,6 +180,7 @@ var _ = Describe("Checking CPU cgroups in the host", func() {
 		id               string
 		cpuCgroupPath    string
 		cpusetCgroupPath string
+		cgroupDriver     string
 		err              error
 		exitCode         int
 		expectedShares   string
@@ -83,6 +192,9 @@ var _ = Describe("Checking CPU cgroups in the host", func() {
 	BeforeEach(func() {
 		id = randomDockerName()
 		args = []string{"--cpus=1", "--cpu-shares=800", "--cpuset-cpus=0", "-dt", "--name", id, Image, "sh"}
+
+		cgroupDriver, err = dockerCgroupDriver()
+		Expect(err).ToNot(HaveOccurred())
 	})
 
 	AfterEach(func() {
@@ -115,12 +227,60 @@ var _ = Describe("Checking CPU cgroups in the host", func() {
 				err = addProcessToCgroup(os.Getpid(), cpusetCgroupPath)
 				Expect(err).ToNot(HaveOccurred())
 
+				var scopeUnit string
+				if cgroupDriver == "systemd" {
+					cid, err := containerID(id)
+					Expect(err).ToNot(HaveOccurred())
+					scopeUnit = fmt.Sprintf("%s-%s.scope", dockerCgroupName, cid)
+
+					stdout, _, exitCode := RunCommand("systemctl", "list-units", scopeUnit)
+					Expect(exitCode).To(BeZero())
+					Expect(stdout).To(ContainSubstring(scopeUnit))
+				}
+
 				// remove container
 				Expect(RemoveDockerContainer(id)).To(BeTrue())
 
 				// cgroups shouldn't exist
 				Expect(cpuCgroupPath).ShouldNot(BeADirectory())
 				Expect(cpusetCgroupPath).ShouldNot(BeADirectory())
+
+				if cgroupDriver == "systemd" {
+					stdout, _, exitCode := RunCommand("systemctl", "list-units", scopeUnit)
+					Expect(exitCode).To(BeZero())
+					Expect(stdout).ToNot(ContainSubstring(scopeUnit))
+				}
+			})
+		})
+
+		Context("on a cgroup v2 host", func() {
+			It("should remove the unified cgroup directory", func() {
+				if os.Getuid() != 0 {
+					Skip("only root user can modify cgroups")
+				}
+
+				if !isCgroupV2() {
+					Skip("host is not using the cgroup v2 unified hierarchy")
+				}
+
+				_, _, exitCode = dockerRun(args...)
+				Expect(exitCode).To(BeZero())
+
+				// cpu and cpuset share the same unified directory on v2
+				cpuCgroupPath, err = containerCgroupPath(id, cgroupCPU)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cpuCgroupPath).Should(BeADirectory())
+
+				cpusetCgroupPath, err = containerCgroupPath(id, cgroupCpuset)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cpusetCgroupPath).To(Equal(cpuCgroupPath))
+
+				err = addProcessToCgroup(os.Getpid(), cpuCgroupPath)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(RemoveDockerContainer(id)).To(BeTrue())
+
+				Expect(cpuCgroupPath).ShouldNot(BeADirectory())
 			})
 		})
 	})
@@ -148,12 +308,25 @@ var _ = Describe("Checking CPU cgroups in the host", func() {
 				cpusetCgroupPath, err = containerCgroupPath(id, cgroupCpuset)
 				Expect(err).ToNot(HaveOccurred())
 
-				for r, v := range map[string]string{
+				expectedFiles := map[string]string{
 					filepath.Join(cpuCgroupPath, sysCPUQuotaFile):      expectedQuota,
 					filepath.Join(cpuCgroupPath, sysCPUPeriodFile):     expectedPeriod,
 					filepath.Join(cpuCgroupPath, sysCPUSharesFile):     expectedShares,
 					filepath.Join(cpusetCgroupPath, sysCpusetCpusFile): expectedCpuset,
-				} {
+				}
+
+				if isCgroupV2() {
+					shares, err := strconv.Atoi(expectedShares)
+					Expect(err).ToNot(HaveOccurred())
+
+					expectedFiles = map[string]string{
+						filepath.Join(cpuCgroupPath, sysCPUMaxFile):        fmt.Sprintf("%s %s", expectedQuota, expectedPeriod),
+						filepath.Join(cpuCgroupPath, sysCPUWeightFile):     strconv.Itoa(cpuSharesToWeight(shares)),
+						filepath.Join(cpusetCgroupPath, sysCpusetCpusFile): expectedCpuset,
+					}
+				}
+
+				for r, v := range expectedFiles {
 					c, err := ioutil.ReadFile(r)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(strings.Trim(string(c), "\n\t ")).To(Equal(v))
@@ -164,3 +337,141 @@ var _ = Describe("Checking CPU cgroups in the host", func() {
 		})
 	})
 })
+
+var _ = Describe("Checking memory, blkio, pids and freezer cgroups in the host", func() {
+	var (
+		id       string
+		exitCode int
+	)
+
+	BeforeEach(func() {
+		id = randomDockerName()
+	})
+
+	AfterEach(func() {
+		Expect(ExistDockerContainer(id)).NotTo(BeTrue())
+	})
+
+	Describe("checking whether the memory cgroup is updated", func() {
+		Context("running a container with memory, memory-swap and memory-reservation limits", func() {
+			It("should be updated", func() {
+				_, _, exitCode = dockerRun("--memory=256m", "--memory-swap=512m", "--memory-reservation=128m",
+					"-dt", "--name", id, Image, "sh")
+				Expect(exitCode).To(BeZero())
+
+				memCgroupPath, err := containerCgroupPath(id, cgroupMemory)
+				Expect(err).ToNot(HaveOccurred())
+
+				expectedFiles := map[string]string{
+					filepath.Join(memCgroupPath, sysMemoryLimitFile):     "268435456",
+					filepath.Join(memCgroupPath, sysMemorySwapLimitFile): "536870912",
+					filepath.Join(memCgroupPath, sysMemorySoftLimitFile): "134217728",
+				}
+				if isCgroupV2() {
+					expectedFiles = map[string]string{
+						filepath.Join(memCgroupPath, sysMemoryMaxFile):     "268435456",
+						filepath.Join(memCgroupPath, sysMemorySwapMaxFile): "268435456",
+						filepath.Join(memCgroupPath, sysMemoryLowFile):     "134217728",
+					}
+				}
+
+				for r, v := range expectedFiles {
+					c, err := ioutil.ReadFile(r)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(strings.Trim(string(c), "\n\t ")).To(Equal(v))
+				}
+
+				Expect(RemoveDockerContainer(id)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("checking whether the blkio cgroup is updated", func() {
+		Context("running a container with a blkio weight and a read/write throttle", func() {
+			It("should apply the limits", func() {
+				_, _, exitCode = dockerRun("--blkio-weight=500",
+					"--device-read-bps", fmt.Sprintf("%s:1mb", BlockDevice),
+					"--device-write-iops", fmt.Sprintf("%s:100", BlockDevice),
+					"-dt", "--name", id, Image, "sh")
+				Expect(exitCode).To(BeZero())
+
+				blkioCgroupPath, err := containerCgroupPath(id, cgroupBlkio)
+				Expect(err).ToNot(HaveOccurred())
+
+				if isCgroupV2() {
+					c, err := ioutil.ReadFile(filepath.Join(blkioCgroupPath, sysIOMaxFile))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(c)).To(ContainSubstring("rbps=1048576"))
+					Expect(string(c)).To(ContainSubstring("wiops=100"))
+				} else {
+					weight, err := ioutil.ReadFile(filepath.Join(blkioCgroupPath, sysBlkioWeightFile))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(strings.Trim(string(weight), "\n\t ")).To(Equal("500"))
+
+					readBps, err := ioutil.ReadFile(filepath.Join(blkioCgroupPath, sysBlkioReadBpsFile))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(readBps)).To(ContainSubstring("1048576"))
+
+					writeIops, err := ioutil.ReadFile(filepath.Join(blkioCgroupPath, sysBlkioWriteIopsFile))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(writeIops)).To(ContainSubstring("100"))
+				}
+
+				Expect(RemoveDockerContainer(id)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("checking whether the pids cgroup is updated", func() {
+		Context("running a container with a pids limit", func() {
+			It("should apply the limit", func() {
+				_, _, exitCode = dockerRun("--pids-limit=64", "-dt", "--name", id, Image, "sh")
+				Expect(exitCode).To(BeZero())
+
+				pidsCgroupPath, err := containerCgroupPath(id, cgroupPids)
+				Expect(err).ToNot(HaveOccurred())
+
+				c, err := ioutil.ReadFile(filepath.Join(pidsCgroupPath, sysPidsMaxFile))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(strings.Trim(string(c), "\n\t ")).To(Equal("64"))
+
+				Expect(RemoveDockerContainer(id)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("checking whether the freezer cgroup transitions on pause/unpause", func() {
+		Context("pausing and unpausing a running container", func() {
+			It("should freeze and thaw", func() {
+				_, _, exitCode = dockerRun("-dt", "--name", id, Image, "sh")
+				Expect(exitCode).To(BeZero())
+
+				freezerCgroupPath, err := containerCgroupPath(id, cgroupFreezer)
+				Expect(err).ToNot(HaveOccurred())
+
+				frozenFile := filepath.Join(freezerCgroupPath, sysFreezerStateFile)
+				frozenValue, thawedValue := freezerStateFrozen, freezerStateThawed
+				if isCgroupV2() {
+					frozenFile = filepath.Join(freezerCgroupPath, sysCgroupFreezeFile)
+					frozenValue, thawedValue = "1", "0"
+				}
+
+				_, _, exitCode = dockerPause(id)
+				Expect(exitCode).To(BeZero())
+
+				c, err := ioutil.ReadFile(frozenFile)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(strings.Trim(string(c), "\n\t ")).To(Equal(frozenValue))
+
+				_, _, exitCode = dockerUnpause(id)
+				Expect(exitCode).To(BeZero())
+
+				c, err = ioutil.ReadFile(frozenFile)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(strings.Trim(string(c), "\n\t ")).To(Equal(thawedValue))
+
+				Expect(RemoveDockerContainer(id)).To(BeTrue())
+			})
+		})
+	})
+})